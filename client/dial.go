@@ -0,0 +1,79 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+
+	"golang.org/x/net/proxy"
+)
+
+// dialStream wraps a stream opened via Tunnel.DialContext so that canceling
+// the dial's context closes the stream, and closing the stream normally
+// stops watching that context.
+type dialStream struct {
+	net.Conn
+	stop func() bool
+}
+
+func (s *dialStream) Close() error {
+	if s.stop != nil {
+		s.stop()
+	}
+	return s.Conn.Close()
+}
+
+// DialContext opens a new stream over the tunnel's smux session, blocking
+// until the tunnel's Noise channel and smux session are ready (see Ready)
+// or ctx is done. dnstt forwards every stream to the single destination the
+// server was configured with, so network and addr are accepted only to
+// satisfy proxy.ContextDialer and are otherwise unused.
+func (t *Tunnel) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	select {
+	case <-t.Ready():
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	stream, err := t.smuxSession.OpenStream()
+	if err != nil {
+		return nil, fmt.Errorf("session %08x opening stream: %w", t.kcpConn.GetConv(), err)
+	}
+	log.Printf("begin stream %08x:%d", t.kcpConn.GetConv(), stream.ID())
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = stream.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() {
+		stream.Close()
+	})
+
+	return &dialStream{Conn: stream, stop: stop}, nil
+}
+
+// Dialer adapts a Tunnel to the standard dialer interfaces so it can be used
+// as a drop-in net.Dialer replacement by HTTP clients, SOCKS servers, or any
+// other Go code, without spawning a dnstt-client subprocess or binding a
+// local TCP listener.
+type Dialer struct {
+	tunnel *Tunnel
+}
+
+// NewDialer returns a Dialer that opens streams over tunnel.
+func NewDialer(tunnel *Tunnel) *Dialer {
+	return &Dialer{tunnel: tunnel}
+}
+
+func (d *Dialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+func (d *Dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return d.tunnel.DialContext(ctx, network, addr)
+}
+
+var (
+	_ proxy.Dialer        = (*Dialer)(nil)
+	_ proxy.ContextDialer = (*Dialer)(nil)
+)
@@ -0,0 +1,138 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	utls "github.com/refraction-networking/utls"
+	"golang.org/x/net/http2"
+)
+
+// dohContentType is the MIME type required by RFC 8484 for both the request
+// body and the response body of a DoH exchange.
+const dohContentType = "application/dns-message"
+
+// dohAddr is the net.Addr reported for a dohPacketConn. DoH has no single
+// underlying transport address (the http.Client may reconnect, reuse
+// connections across multiple resolver IPs, etc.), so this is just the
+// resolver URL for logging purposes.
+type dohAddr string
+
+func (a dohAddr) Network() string { return "doh" }
+func (a dohAddr) String() string  { return string(a) }
+
+// dohPacketConn adapts a DNS-over-HTTPS resolver (RFC 8484) to the
+// net.PacketConn interface expected by DNSPacketConn. Each WriteTo issues a
+// POST of the wire-format DNS message and queues the response body for the
+// next ReadFrom. The underlying client keeps an HTTP/2 connection open
+// across queries so repeated lookups reuse the same TLS session and stream
+// multiplex instead of reconnecting.
+//
+// net/http's own HTTP/2 upgrade only fires when the conn returned by
+// DialTLSContext type-asserts to *tls.Conn, which a *utls.UConn never does,
+// so an http.Transport configured this way is silently stuck on HTTP/1.1.
+// dohPacketConn instead drives http2.Transport directly: it speaks HTTP/2
+// over whatever net.Conn its own DialTLSContext hands it, uTLS included.
+type dohPacketConn struct {
+	url    string
+	client *http.Client
+
+	mu    sync.Mutex
+	queue [][]byte
+
+	notify    chan struct{}
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// newDoHPacketConn returns a dohPacketConn that POSTs DNS messages to url,
+// presenting helloID's ClientHello for the underlying TLS connections.
+func newDoHPacketConn(url string, helloID utls.ClientHelloID) (*dohPacketConn, error) {
+	transport := &http2.Transport{
+		IdleConnTimeout: 90 * time.Second,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return dialUTLS(ctx, network, addr, helloID, []string{"h2"})
+		},
+	}
+	return &dohPacketConn{
+		url: url,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   30 * time.Second,
+		},
+		notify: make(chan struct{}, 1),
+		closed: make(chan struct{}),
+	}, nil
+}
+
+func (c *dohPacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	for {
+		c.mu.Lock()
+		if len(c.queue) > 0 {
+			msg := c.queue[0]
+			c.queue = c.queue[1:]
+			c.mu.Unlock()
+			return copy(p, msg), dohAddr(c.url), nil
+		}
+		c.mu.Unlock()
+
+		select {
+		case <-c.notify:
+		case <-c.closed:
+			return 0, nil, net.ErrClosed
+		}
+	}
+}
+
+func (c *dohPacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, c.url, bytes.NewReader(p))
+	if err != nil {
+		return 0, fmt.Errorf("building DoH request: %w", err)
+	}
+	req.Header.Set("Content-Type", dohContentType)
+	req.Header.Set("Accept", dohContentType)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("DoH request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("DoH request: unexpected status %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return 0, fmt.Errorf("reading DoH response: %w", err)
+	}
+
+	c.mu.Lock()
+	c.queue = append(c.queue, body)
+	c.mu.Unlock()
+	select {
+	case c.notify <- struct{}{}:
+	default:
+	}
+
+	return len(p), nil
+}
+
+func (c *dohPacketConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.client.CloseIdleConnections()
+	})
+	return nil
+}
+
+func (c *dohPacketConn) LocalAddr() net.Addr                { return dohAddr(c.url) }
+func (c *dohPacketConn) SetDeadline(t time.Time) error      { return nil }
+func (c *dohPacketConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *dohPacketConn) SetWriteDeadline(t time.Time) error { return nil }
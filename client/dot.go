@@ -0,0 +1,155 @@
+package client
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	utls "github.com/refraction-networking/utls"
+)
+
+// dotPoolSize caps the number of idle TLS connections a dotPacketConn keeps
+// open to its resolver.
+const dotPoolSize = 4
+
+// dotAddr is the net.Addr reported for a dotPacketConn.
+type dotAddr string
+
+func (a dotAddr) Network() string { return "dot" }
+func (a dotAddr) String() string  { return string(a) }
+
+// dotPacketConn adapts a DNS-over-TLS resolver (RFC 7858) to the
+// net.PacketConn interface expected by DNSPacketConn. It keeps a small pool
+// of long-lived TLS connections to the resolver; each query is framed with
+// the 2-byte big-endian length prefix used by DNS-over-TCP, and the
+// connection is returned to the pool once its response arrives.
+type dotPacketConn struct {
+	addr    string
+	helloID utls.ClientHelloID
+
+	mu    sync.Mutex
+	conns []net.Conn
+
+	incoming  chan []byte
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// newDoTPacketConn returns a dotPacketConn that dials resolverAddr as
+// needed, presenting helloID's ClientHello for the TLS handshake.
+func newDoTPacketConn(resolverAddr string, helloID utls.ClientHelloID) (*dotPacketConn, error) {
+	if _, _, err := net.SplitHostPort(resolverAddr); err != nil {
+		return nil, fmt.Errorf("invalid DoT address %q: %w", resolverAddr, err)
+	}
+	return &dotPacketConn{
+		addr:     resolverAddr,
+		helloID:  helloID,
+		incoming: make(chan []byte, 64),
+		closed:   make(chan struct{}),
+	}, nil
+}
+
+func (c *dotPacketConn) getConn() (net.Conn, error) {
+	c.mu.Lock()
+	if n := len(c.conns); n > 0 {
+		conn := c.conns[n-1]
+		c.conns = c.conns[:n-1]
+		c.mu.Unlock()
+		return conn, nil
+	}
+	c.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	// RFC 7858 recommends (but does not require) the "dot" ALPN identifier.
+	conn, err := dialUTLS(ctx, "tcp", c.addr, c.helloID, []string{"dot"})
+	if err != nil {
+		return nil, fmt.Errorf("dialing DoT resolver: %w", err)
+	}
+	return conn, nil
+}
+
+func (c *dotPacketConn) putConn(conn net.Conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.conns) >= dotPoolSize {
+		conn.Close()
+		return
+	}
+	c.conns = append(c.conns, conn)
+}
+
+func (c *dotPacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	conn, err := c.getConn()
+	if err != nil {
+		return 0, err
+	}
+
+	var lengthPrefix [2]byte
+	binary.BigEndian.PutUint16(lengthPrefix[:], uint16(len(p)))
+	if _, err := conn.Write(lengthPrefix[:]); err != nil {
+		conn.Close()
+		return 0, fmt.Errorf("writing DoT length prefix: %w", err)
+	}
+	if _, err := conn.Write(p); err != nil {
+		conn.Close()
+		return 0, fmt.Errorf("writing DoT message: %w", err)
+	}
+
+	go c.readResponse(conn)
+
+	return len(p), nil
+}
+
+// readResponse reads exactly one length-prefixed DNS message from conn,
+// queues it for ReadFrom, and returns conn to the pool.
+func (c *dotPacketConn) readResponse(conn net.Conn) {
+	var lengthPrefix [2]byte
+	if _, err := io.ReadFull(conn, lengthPrefix[:]); err != nil {
+		conn.Close()
+		return
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(lengthPrefix[:]))
+	if _, err := io.ReadFull(conn, msg); err != nil {
+		conn.Close()
+		return
+	}
+
+	select {
+	case c.incoming <- msg:
+		c.putConn(conn)
+	case <-c.closed:
+		conn.Close()
+	}
+}
+
+func (c *dotPacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	select {
+	case msg := <-c.incoming:
+		return copy(p, msg), dotAddr(c.addr), nil
+	case <-c.closed:
+		return 0, nil, net.ErrClosed
+	}
+}
+
+func (c *dotPacketConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.mu.Lock()
+		for _, conn := range c.conns {
+			conn.Close()
+		}
+		c.conns = nil
+		c.mu.Unlock()
+	})
+	return nil
+}
+
+func (c *dotPacketConn) LocalAddr() net.Addr                { return dotAddr(c.addr) }
+func (c *dotPacketConn) SetDeadline(t time.Time) error      { return nil }
+func (c *dotPacketConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *dotPacketConn) SetWriteDeadline(t time.Time) error { return nil }
@@ -0,0 +1,57 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	utls "github.com/refraction-networking/utls"
+)
+
+// DefaultTLSFingerprint is used for a Resolver's TLSFingerprint when none is
+// specified.
+const DefaultTLSFingerprint = "chrome"
+
+// tlsFingerprints maps the -tls-fingerprint flag value to the uTLS
+// ClientHelloID that DoH and DoT connections present during their TLS
+// handshake, so a censor fingerprinting the Go standard library's
+// ClientHello sees an ordinary browser/OS instead.
+var tlsFingerprints = map[string]utls.ClientHelloID{
+	"chrome":  utls.HelloChrome_Auto,
+	"firefox": utls.HelloFirefox_Auto,
+	"ios":     utls.HelloIOS_Auto,
+}
+
+// lookupTLSFingerprint resolves a -tls-fingerprint flag value to a uTLS
+// ClientHelloID. An empty name means DefaultTLSFingerprint.
+func lookupTLSFingerprint(name string) (utls.ClientHelloID, error) {
+	if name == "" {
+		name = DefaultTLSFingerprint
+	}
+	helloID, ok := tlsFingerprints[name]
+	if !ok {
+		return utls.ClientHelloID{}, fmt.Errorf("unsupported TLS fingerprint: %s", name)
+	}
+	return helloID, nil
+}
+
+// dialUTLS dials addr over network and performs a TLS handshake that
+// presents helloID's ClientHello, offering nextProtos via ALPN.
+func dialUTLS(ctx context.Context, network, addr string, helloID utls.ClientHelloID, nextProtos []string) (net.Conn, error) {
+	rawConn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+
+	uconn := utls.UClient(rawConn, &utls.Config{ServerName: host, NextProtos: nextProtos}, helloID)
+	if err := uconn.HandshakeContext(ctx); err != nil {
+		rawConn.Close()
+		return nil, fmt.Errorf("uTLS handshake: %w", err)
+	}
+	return uconn, nil
+}
@@ -0,0 +1,340 @@
+package client
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LoadBalancePolicy selects how Outbound.Start distributes newly accepted
+// TCP connections across the tunnels backing its (Resolver, TunnelServer)
+// pairs.
+type LoadBalancePolicy int
+
+const (
+	// LoadBalanceRoundRobin cycles through live tunnels in order.
+	LoadBalanceRoundRobin LoadBalancePolicy = iota
+	// LoadBalanceLeastLoaded sends each new stream to whichever live
+	// tunnel currently has the fewest active streams.
+	LoadBalanceLeastLoaded
+	// LoadBalancePreference always prefers the lowest-index live tunnel
+	// (the order Resolvers/TunnelServers were given in), falling back to
+	// the next one only once the preferred tunnel is down.
+	LoadBalancePreference
+)
+
+// loadBalancePolicyNames maps the -loadbalance-policy flag value accepted on
+// the command line to a LoadBalancePolicy.
+var loadBalancePolicyNames = map[string]LoadBalancePolicy{
+	"round-robin":  LoadBalanceRoundRobin,
+	"least-loaded": LoadBalanceLeastLoaded,
+	"preference":   LoadBalancePreference,
+}
+
+// ParseLoadBalancePolicy resolves a -loadbalance-policy flag value to a
+// LoadBalancePolicy.
+func ParseLoadBalancePolicy(name string) (LoadBalancePolicy, error) {
+	policy, ok := loadBalancePolicyNames[name]
+	if !ok {
+		return 0, fmt.Errorf("unsupported load balance policy: %s", name)
+	}
+	return policy, nil
+}
+
+const (
+	// healthCheckInterval is how often a managedTunnel is checked for
+	// liveness.
+	healthCheckInterval = 30 * time.Second
+	// probeTimeout bounds how long a single DNS RTT probe is allowed to
+	// take before its resolver is considered unreachable. It's kept well
+	// under smux's own idleTimeout so a blackholed resolver is caught and
+	// swapped out long before smux would notice anything is wrong.
+	probeTimeout = 5 * time.Second
+)
+
+// dnsProbeQuery is a minimal, well-formed DNS query (a root NS query) used
+// only to measure a resolver's round-trip time during health checks. Its
+// content doesn't matter beyond that a resolver capable of answering DNS
+// queries sends back some response to it.
+var dnsProbeQuery = []byte{
+	0x00, 0x00, // ID
+	0x01, 0x00, // flags: recursion desired
+	0x00, 0x01, // QDCOUNT=1
+	0x00, 0x00, // ANCOUNT=0
+	0x00, 0x00, // NSCOUNT=0
+	0x00, 0x00, // ARCOUNT=0
+	0x00,       // QNAME: root
+	0x00, 0x02, // QTYPE=NS
+	0x00, 0x01, // QCLASS=IN
+}
+
+// probeResolver dials a throwaway connection to resolver (independent of any
+// Tunnel's own resolver connection, so as not to steal a packet a live KCP
+// session is waiting on) and measures how long it takes to get back any
+// response to a DNS query. An error means resolver didn't answer within
+// timeout, e.g. because it's blackholing packets.
+func probeResolver(resolver Resolver, timeout time.Duration) (time.Duration, error) {
+	conn, addr, err := dialResolverConn(resolver)
+	if err != nil {
+		return 0, fmt.Errorf("dialing resolver: %w", err)
+	}
+	defer conn.Close()
+
+	start := time.Now()
+
+	// conn's own deadline methods are no-ops for DoH/DoT (a single query
+	// already carries its own HTTP timeout, and a blackholed DoT server
+	// never completes its response goroutine), and their WriteTo may itself
+	// block on the HTTP round trip or on dialing, so time out by racing the
+	// write and the read against a timer instead of relying on SetDeadline.
+	result := make(chan error, 1)
+	go func() {
+		if _, err := conn.WriteTo(dnsProbeQuery, addr); err != nil {
+			result <- fmt.Errorf("writing probe query: %w", err)
+			return
+		}
+		buf := make([]byte, 512)
+		if _, _, err := conn.ReadFrom(buf); err != nil {
+			result <- fmt.Errorf("reading probe response: %w", err)
+			return
+		}
+		result <- nil
+	}()
+
+	select {
+	case err := <-result:
+		if err != nil {
+			return 0, err
+		}
+		return time.Since(start), nil
+	case <-time.After(timeout):
+		return 0, fmt.Errorf("probe timed out after %s", timeout)
+	}
+}
+
+// managedTunnel owns the *Tunnel for one (Resolver, TunnelServer) pair plus
+// the bookkeeping Outbound needs to route around it when it's down.
+//
+// resolvers holds every candidate resolver configured for this
+// TunnelServer, not just the one currently in use: when healthCheck finds
+// the active resolver unreachable but the tunnel's KCP/Noise/smux
+// conversation is still alive, it fails over by calling Tunnel.SwapResolver
+// to the next candidate in this list, rather than discarding the session.
+type managedTunnel struct {
+	resolvers    []Resolver
+	tunnelServer TunnelServer
+
+	mu          sync.Mutex
+	resolverIdx int
+	tunnel      *Tunnel
+	live        bool
+
+	active int32 // active streams; accessed with sync/atomic
+}
+
+// newManagedTunnel returns a managedTunnel for tunnelServer that starts on
+// resolvers[startIdx] and fails over across the rest of resolvers.
+func newManagedTunnel(resolvers []Resolver, startIdx int, tunnelServer TunnelServer) *managedTunnel {
+	return &managedTunnel{
+		resolvers:    resolvers,
+		resolverIdx:  startIdx,
+		tunnelServer: tunnelServer,
+	}
+}
+
+// currentResolver returns the resolver the managed tunnel is presently
+// using.
+func (m *managedTunnel) currentResolver() Resolver {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.resolvers[m.resolverIdx]
+}
+
+// advanceResolver moves to, and returns, the next candidate resolver in the
+// rotation.
+func (m *managedTunnel) advanceResolver() Resolver {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.resolverIdx = (m.resolverIdx + 1) % len(m.resolvers)
+	return m.resolvers[m.resolverIdx]
+}
+
+// connect establishes a brand new tunnel for this pair's current resolver,
+// replacing whatever tunnel this managedTunnel previously held. It's used
+// for the initial connection and for recovering from a session that's
+// failed outright (e.g. its smux session closed); a resolver that's merely
+// unreachable is instead handled by failover in healthCheck, which swaps
+// the resolver under the existing session rather than rebuilding it.
+func (m *managedTunnel) connect() error {
+	resolver := m.currentResolver()
+	tunnel, err := NewTunnel(resolver, m.tunnelServer)
+	if err != nil {
+		return fmt.Errorf("creating tunnel: %w", err)
+	}
+	if err := tunnel.InitiateResolverConnection(); err != nil {
+		return fmt.Errorf("initiating resolver connection: %w", err)
+	}
+	if err := tunnel.InitiateDNSPacketConn(m.tunnelServer.Addr); err != nil {
+		tunnel.Close()
+		return fmt.Errorf("initiating DNS packet connection: %w", err)
+	}
+	if err := tunnel.InitiateKCPConn(m.tunnelServer.MTU); err != nil {
+		tunnel.Close()
+		return fmt.Errorf("initiating KCP connection: %w", err)
+	}
+	if err := tunnel.InitiateNoiseChannel(); err != nil {
+		tunnel.Close()
+		return fmt.Errorf("initiating Noise channel: %w", err)
+	}
+	if err := tunnel.InitiateSmuxSession(); err != nil {
+		tunnel.Close()
+		return fmt.Errorf("initiating smux session: %w", err)
+	}
+
+	m.mu.Lock()
+	m.tunnel = tunnel
+	m.live = true
+	m.mu.Unlock()
+	return nil
+}
+
+// setLive updates whether dispatch should be routing new streams to this
+// pair, without otherwise disturbing its tunnel.
+func (m *managedTunnel) setLive(live bool) {
+	m.mu.Lock()
+	m.live = live
+	m.mu.Unlock()
+}
+
+func (m *managedTunnel) isLive() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.live
+}
+
+// loadedTunnel returns the current *Tunnel and whether it's live, atomically
+// with respect to connect/setLive.
+func (m *managedTunnel) loadedTunnel() (*Tunnel, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.tunnel, m.live
+}
+
+// closeTunnel closes the underlying tunnel, if any, and marks the pair down.
+// Unlike a failover swap, this discards the KCP/Noise/smux session
+// entirely, so it's only appropriate when that session is already gone
+// (shutdown, or a session that's failed outright).
+func (m *managedTunnel) closeTunnel() {
+	m.mu.Lock()
+	tunnel := m.tunnel
+	m.live = false
+	m.mu.Unlock()
+
+	if tunnel != nil {
+		tunnel.Close()
+	}
+}
+
+// healthCheck runs until stop is closed, periodically verifying the tunnel
+// is still usable and, if not, either swapping in a working resolver or
+// reconnecting from scratch.
+func (m *managedTunnel) healthCheck(stop <-chan struct{}) {
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			m.runHealthCheck()
+		}
+	}
+}
+
+func (m *managedTunnel) runHealthCheck() {
+	tunnel, live := m.loadedTunnel()
+	if !live || tunnel == nil {
+		if err := m.connect(); err != nil {
+			log.Printf("tunnel server %s: reconnect failed: %v", m.tunnelServer.Addr, err)
+		} else {
+			log.Printf("tunnel server %s: tunnel established", m.tunnelServer.Addr)
+		}
+		return
+	}
+
+	if tunnel.smuxSession.IsClosed() {
+		// The KCP/Noise/smux conversation itself is gone, so there's
+		// nothing left to preserve; rebuild it from scratch.
+		log.Printf("resolver %s: smux session closed, reconnecting", m.currentResolver().ResolverAddr)
+		m.closeTunnel()
+		if err := m.connect(); err != nil {
+			log.Printf("tunnel server %s: reconnect failed: %v", m.tunnelServer.Addr, err)
+		} else {
+			log.Printf("tunnel server %s: tunnel restored", m.tunnelServer.Addr)
+		}
+		return
+	}
+
+	if rtt, err := probeResolver(m.currentResolver(), probeTimeout); err != nil {
+		// The session above it is still alive, but its current resolver
+		// isn't answering — likely blackholed rather than cleanly closed,
+		// so smux's own keepalive wouldn't notice for up to idleTimeout.
+		// Swap the transport underneath the session instead of tearing
+		// anything down, so streams already in flight keep running.
+		m.setLive(false)
+		next := m.advanceResolver()
+		log.Printf("resolver %s: health probe failed (%v), swapping to %s", tunnel.Resolver.ResolverAddr, err, next.ResolverAddr)
+		if err := tunnel.SwapResolver(next); err != nil {
+			log.Printf("resolver %s: swap failed: %v", next.ResolverAddr, err)
+			return
+		}
+		m.setLive(true)
+	} else {
+		log.Printf("resolver %s: health probe OK (%s)", m.currentResolver().ResolverAddr, rtt)
+	}
+}
+
+// pickTunnel chooses the managedTunnel that should receive the next
+// accepted connection, according to policy. It returns nil if none of
+// tunnels are currently live.
+func pickTunnel(tunnels []*managedTunnel, policy LoadBalancePolicy, roundRobinNext *uint32) *managedTunnel {
+	switch policy {
+	case LoadBalancePreference:
+		for _, m := range tunnels {
+			if m.isLive() {
+				return m
+			}
+		}
+		return nil
+
+	case LoadBalanceLeastLoaded:
+		var best *managedTunnel
+		var bestActive int32
+		for _, m := range tunnels {
+			if !m.isLive() {
+				continue
+			}
+			active := atomic.LoadInt32(&m.active)
+			if best == nil || active < bestActive {
+				best = m
+				bestActive = active
+			}
+		}
+		return best
+
+	default: // LoadBalanceRoundRobin
+		n := uint32(len(tunnels))
+		if n == 0 {
+			return nil
+		}
+		for i := uint32(0); i < n; i++ {
+			idx := (atomic.AddUint32(roundRobinNext, 1) - 1) % n
+			if tunnels[idx].isLive() {
+				return tunnels[idx]
+			}
+		}
+		return nil
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"sync/atomic"
 
 	"github.com/mahsanet/dnstt/dns"
 	"github.com/mahsanet/dnstt/noise"
@@ -20,18 +21,31 @@ const (
 type Resolver struct {
 	ResolverType ResolverType
 	ResolverAddr string
+	// TLSFingerprint selects the uTLS ClientHello presented for the DoH or
+	// DoT TLS handshake (ignored for ResolverTypeUDP). Empty means
+	// DefaultTLSFingerprint.
+	TLSFingerprint string
 }
 
-func NewResolver(resolverType ResolverType, resolverAddr string) (Resolver, error) {
+func NewResolver(resolverType ResolverType, resolverAddr string, tlsFingerprint string) (Resolver, error) {
 	switch resolverType {
-	case ResolverTypeUDP:
+	case ResolverTypeUDP, ResolverTypeDOH, ResolverTypeDOT:
 		break
 	default:
 		return Resolver{}, fmt.Errorf("unsupported resolver type: %s", resolverType)
 	}
+
+	if tlsFingerprint == "" {
+		tlsFingerprint = DefaultTLSFingerprint
+	}
+	if _, err := lookupTLSFingerprint(tlsFingerprint); err != nil {
+		return Resolver{}, err
+	}
+
 	return Resolver{
-		ResolverType: resolverType,
-		ResolverAddr: resolverAddr,
+		ResolverType:   resolverType,
+		ResolverAddr:   resolverAddr,
+		TLSFingerprint: tlsFingerprint,
 	}, nil
 }
 
@@ -70,7 +84,12 @@ func NewTunnelServer(addr string, pubKeyString string) (TunnelServer, error) {
 type Outbound struct {
 	Resolvers     []Resolver
 	TunnelServers []TunnelServer
-	tunnels       []*Tunnel
+	// LoadBalancePolicy selects how accepted connections are dispatched
+	// across the tunnels for each (Resolver, TunnelServer) pair. The zero
+	// value is LoadBalanceRoundRobin.
+	LoadBalancePolicy LoadBalancePolicy
+
+	tunnels []*managedTunnel
 }
 
 func NewOutbound(resolvers []Resolver, tunnelServers []TunnelServer) *Outbound {
@@ -92,52 +111,68 @@ func (o *Outbound) Start(bind string) error {
 	}
 	defer ln.Close()
 
-	// For now, just use the first tunnel server and resolver. In the future,
-	// we may want to support multiple tunnel servers and resolvers, and
-	// implement some kind of load balancing or failover strategy.
-	resolver := o.Resolvers[0]
-	tunnelServer := o.TunnelServers[0]
-
-	tunnel, err := NewTunnel(resolver, tunnelServer)
-	if err != nil {
-		return fmt.Errorf("failed to create tunnel: %w", err)
-	}
-	defer tunnel.Close()
-
-	o.tunnels = []*Tunnel{tunnel}
-
-	if err := tunnel.InitiateResolverConnection(); err != nil {
-		return fmt.Errorf("failed to initiate connection to resolver: %w", err)
+	for _, tunnelServer := range o.TunnelServers {
+		for i := range o.Resolvers {
+			o.tunnels = append(o.tunnels, newManagedTunnel(o.Resolvers, i, tunnelServer))
+		}
 	}
-
-	if err := tunnel.InitiateDNSPacketConn(tunnelServer.Addr); err != nil {
-		return fmt.Errorf("failed to initiate DNS packet connection: %w", err)
+	if len(o.tunnels) == 0 {
+		return fmt.Errorf("no resolvers/tunnel servers configured")
 	}
 
-	log.Printf("effective MTU %d", tunnelServer.MTU)
-
-	if err := tunnel.InitiateKCPConn(tunnelServer.MTU); err != nil {
-		return fmt.Errorf("failed to initiate KCP connection: %w", err)
-	}
+	stop := make(chan struct{})
+	defer close(stop)
+	defer func() {
+		for _, m := range o.tunnels {
+			m.closeTunnel()
+		}
+	}()
 
-	if err := tunnel.InitiateNoiseChannel(); err != nil {
-		return fmt.Errorf("failed to initiate Noise channel: %w", err)
+	var live bool
+	for _, m := range o.tunnels {
+		if err := m.connect(); err != nil {
+			log.Printf("resolver %s: failed to initiate tunnel: %v", m.currentResolver().ResolverAddr, err)
+			continue
+		}
+		live = true
+		log.Printf("effective MTU %d", m.tunnelServer.MTU)
+		go m.healthCheck(stop)
 	}
-
-	if err := tunnel.InitiateSmuxSession(); err != nil {
-		return fmt.Errorf("failed to initiate smux session: %w", err)
+	if !live {
+		return fmt.Errorf("failed to initiate a tunnel to any resolver")
 	}
 
+	var roundRobinNext uint32
 	for {
 		local, err := ln.Accept()
 		if err != nil {
 			continue
 		}
 
+		m := pickTunnel(o.tunnels, o.LoadBalancePolicy, &roundRobinNext)
+		if m == nil {
+			log.Printf("no live tunnels available, dropping connection")
+			local.Close()
+			continue
+		}
+
 		go func() {
 			defer local.Close()
-			err := tunnel.Handle(local.(*net.TCPConn))
-			if err != nil {
+
+			tunnel, live := m.loadedTunnel()
+			if !live {
+				return
+			}
+
+			atomic.AddInt32(&m.active, 1)
+			defer atomic.AddInt32(&m.active, -1)
+
+			if err := tunnel.Handle(local.(*net.TCPConn)); err != nil {
+				// This is a failure of one forwarded TCP connection, not
+				// necessarily of the tunnel it rode on — plenty of other
+				// streams may be healthy on the same resolver/KCP session,
+				// so don't tear the whole thing down here. healthCheck is
+				// what decides whether the tunnel itself is dead.
 				log.Printf("handle: %v", err)
 			}
 		}()
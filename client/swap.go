@@ -0,0 +1,75 @@
+package client
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// swapTarget pairs a resolver's net.PacketConn with the net.Addr packets
+// should be written to, so swappablePacketConn can replace both atomically
+// in one step.
+type swapTarget struct {
+	conn net.PacketConn
+	addr net.Addr
+}
+
+// swappablePacketConn is a net.PacketConn whose underlying conn and
+// destination address can be atomically replaced while in use. Tunnel wraps
+// its resolverConn in one of these before handing it to NewDNSPacketConn,
+// so Tunnel.SwapResolver can replace the transport underneath an
+// established KCP conversation, Noise channel, and smux session without any
+// of them noticing.
+//
+// DNSPacketConn and the KCP session above it each capture the destination
+// net.Addr once, at construction, and pass that same (increasingly stale)
+// value into every WriteTo call for the life of the session. WriteTo below
+// therefore ignores the addr it's given and substitutes the current target
+// instead — otherwise, for the UDP resolver type, a swap to a resolver at a
+// different address would silently keep sending queries to the old one.
+type swappablePacketConn struct {
+	target atomic.Pointer[swapTarget]
+}
+
+func newSwappablePacketConn(conn net.PacketConn, addr net.Addr) *swappablePacketConn {
+	s := &swappablePacketConn{}
+	s.store(conn, addr)
+	return s
+}
+
+func (s *swappablePacketConn) store(conn net.PacketConn, addr net.Addr) {
+	s.target.Store(&swapTarget{conn: conn, addr: addr})
+}
+
+func (s *swappablePacketConn) load() *swapTarget {
+	return s.target.Load()
+}
+
+func (s *swappablePacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	return s.load().conn.ReadFrom(p)
+}
+
+func (s *swappablePacketConn) WriteTo(p []byte, _ net.Addr) (int, error) {
+	t := s.load()
+	return t.conn.WriteTo(p, t.addr)
+}
+
+func (s *swappablePacketConn) Close() error {
+	return s.load().conn.Close()
+}
+
+func (s *swappablePacketConn) LocalAddr() net.Addr {
+	return s.load().conn.LocalAddr()
+}
+
+func (s *swappablePacketConn) SetDeadline(t time.Time) error {
+	return s.load().conn.SetDeadline(t)
+}
+
+func (s *swappablePacketConn) SetReadDeadline(t time.Time) error {
+	return s.load().conn.SetReadDeadline(t)
+}
+
+func (s *swappablePacketConn) SetWriteDeadline(t time.Time) error {
+	return s.load().conn.SetWriteDeadline(t)
+}
@@ -25,48 +25,128 @@ type Tunnel struct {
 	addr         net.Addr
 
 	resolverConn  net.PacketConn
+	swappableConn *swappablePacketConn
 	dnsPacketConn *DNSPacketConn
 	kcpConn       *kcp.UDPSession
 	noiseChannel  io.ReadWriteCloser
 	smuxSession   *smux.Session
+
+	ready     chan struct{}
+	readyOnce sync.Once
 }
 
 func NewTunnel(resolver Resolver, tunnelServer TunnelServer) (*Tunnel, error) {
 	return &Tunnel{
 		Resolver:     resolver,
 		TunnelServer: tunnelServer,
+		ready:        make(chan struct{}),
 	}, nil
 }
 
-func (t *Tunnel) InitiateResolverConnection() error {
-	switch t.Resolver.ResolverType {
+// Ready returns a channel that is closed once the tunnel's Noise channel and
+// smux session have both been established, i.e. once it's usable for
+// OpenStream/DialContext/Handle.
+func (t *Tunnel) Ready() <-chan struct{} {
+	return t.ready
+}
+
+// dialResolverConn opens the net.PacketConn and resolves the net.Addr
+// appropriate for resolver, without touching any Tunnel state. It is shared
+// by InitiateResolverConnection and SwapResolver so both construct resolver
+// connections identically.
+func dialResolverConn(resolver Resolver) (net.PacketConn, net.Addr, error) {
+	switch resolver.ResolverType {
 	case ResolverTypeUDP:
-		addr, err := net.ResolveUDPAddr("udp", t.Resolver.ResolverAddr)
+		addr, err := net.ResolveUDPAddr("udp", resolver.ResolverAddr)
 		if err != nil {
-			return err
+			return nil, nil, err
 		}
 		conn, err := net.ListenUDP("udp", nil)
 		if err != nil {
-			return err
+			return nil, nil, err
 		}
-		t.resolverConn = conn
-		t.addr = addr
-		return nil
+		return conn, addr, nil
+	case ResolverTypeDOH:
+		helloID, err := lookupTLSFingerprint(resolver.TLSFingerprint)
+		if err != nil {
+			return nil, nil, err
+		}
+		conn, err := newDoHPacketConn(resolver.ResolverAddr, helloID)
+		if err != nil {
+			return nil, nil, err
+		}
+		return conn, dohAddr(resolver.ResolverAddr), nil
+	case ResolverTypeDOT:
+		helloID, err := lookupTLSFingerprint(resolver.TLSFingerprint)
+		if err != nil {
+			return nil, nil, err
+		}
+		conn, err := newDoTPacketConn(resolver.ResolverAddr, helloID)
+		if err != nil {
+			return nil, nil, err
+		}
+		return conn, dotAddr(resolver.ResolverAddr), nil
 	default:
-		return fmt.Errorf("unsupported resolver type: %s", t.Resolver.ResolverType)
+		return nil, nil, fmt.Errorf("unsupported resolver type: %s", resolver.ResolverType)
 	}
 }
 
+func (t *Tunnel) InitiateResolverConnection() error {
+	conn, addr, err := dialResolverConn(t.Resolver)
+	if err != nil {
+		return err
+	}
+	t.resolverConn = conn
+	t.addr = addr
+	return nil
+}
+
 func (t *Tunnel) InitiateDNSPacketConn(domain dns.Name) error {
 	switch t.Resolver.ResolverType {
-	case ResolverTypeUDP:
-		t.dnsPacketConn = NewDNSPacketConn(t.resolverConn, t.addr, domain)
+	case ResolverTypeUDP, ResolverTypeDOH, ResolverTypeDOT:
+		t.swappableConn = newSwappablePacketConn(t.resolverConn, t.addr)
+		t.dnsPacketConn = NewDNSPacketConn(t.swappableConn, t.addr, domain)
 		return nil
 	default:
 		return fmt.Errorf("unsupported resolver type: %s", t.Resolver.ResolverType)
 	}
 }
 
+// SwapResolver replaces the tunnel's underlying resolver connection and
+// destination address in place, without tearing down the KCP conversation,
+// Noise channel, or smux session running above it. This is what lets a
+// long-lived tunnel survive a network switch or a resolver going dark
+// mid-session, including switching to a UDP resolver at a different
+// address: turbotunnel already keys the server-side session by ClientID
+// rather than by the transport's 5-tuple, so once the new conn starts
+// carrying datagrams the server simply resumes delivering to the same
+// session.
+func (t *Tunnel) SwapResolver(resolver Resolver) error {
+	if t.swappableConn == nil {
+		return fmt.Errorf("tunnel has no resolver connection to swap")
+	}
+
+	conn, addr, err := dialResolverConn(resolver)
+	if err != nil {
+		return fmt.Errorf("dialing replacement resolver connection: %w", err)
+	}
+
+	old := t.resolverConn
+	t.Resolver = resolver
+	t.resolverConn = conn
+	t.addr = addr
+	t.swappableConn.store(conn, addr)
+
+	if old != nil {
+		_ = old.Close()
+	}
+
+	if t.kcpConn != nil {
+		log.Printf("session %08x: swapped resolver connection to %s", t.kcpConn.GetConv(), resolver.ResolverAddr)
+	}
+	return nil
+}
+
 func (t *Tunnel) InitiateKCPConn(mtu int) error {
 	// Open a KCP conn on the PacketConn.
 	conn, err := kcp.NewConn2(t.addr, nil, 0, 0, t.dnsPacketConn)
@@ -115,6 +195,7 @@ func (t *Tunnel) InitiateSmuxSession() error {
 	}
 	t.smuxSession = sess
 	log.Printf("smux session established for session %08x", t.kcpConn.GetConv())
+	t.readyOnce.Do(func() { close(t.ready) })
 	return nil
 }
 
@@ -127,10 +208,10 @@ func (t *Tunnel) OpenStream() (net.Conn, error) {
 	return stream, nil
 }
 
-func (t *Tunnel) Handle(lconn *net.TCPConn) error {
-	stream, err := t.smuxSession.OpenStream()
-	if err != nil {
-		return fmt.Errorf("session %08x opening stream: %w", t.kcpConn.GetConv(), err)
+func (t *Tunnel) Handle(lconn *net.TCPConn) (err error) {
+	stream, openErr := t.smuxSession.OpenStream()
+	if openErr != nil {
+		return fmt.Errorf("session %08x opening stream: %w", t.kcpConn.GetConv(), openErr)
 	}
 
 	defer func() {
@@ -140,30 +221,40 @@ func (t *Tunnel) Handle(lconn *net.TCPConn) error {
 
 	log.Printf("begin stream %08x:%d", t.kcpConn.GetConv(), stream.ID())
 
+	// setErr records the first real copy failure (if any) into the named
+	// return value, so callers can tell a broken stream from a clean
+	// shutdown (Outbound logs it; tunnel liveness is left to healthCheck).
+	var errOnce sync.Once
+	setErr := func(e error) {
+		errOnce.Do(func() { err = e })
+	}
+
 	var wg sync.WaitGroup
 	wg.Add(2)
 	go func() {
 		defer wg.Done()
-		_, err := io.Copy(stream, lconn)
-		if err == io.EOF {
+		_, copyErr := io.Copy(stream, lconn)
+		if copyErr == io.EOF {
 			// smux Stream.Write may return io.EOF.
-			err = nil
+			copyErr = nil
 		}
-		if err != nil && !errors.Is(err, io.ErrClosedPipe) {
-			log.Printf("stream %08x:%d copy stream←local: %v", t.kcpConn.GetConv(), stream.ID(), err)
+		if copyErr != nil && !errors.Is(copyErr, io.ErrClosedPipe) {
+			log.Printf("stream %08x:%d copy stream←local: %v", t.kcpConn.GetConv(), stream.ID(), copyErr)
+			setErr(copyErr)
 		}
 		lconn.CloseRead()
 		stream.Close()
 	}()
 	go func() {
 		defer wg.Done()
-		_, err := io.Copy(lconn, stream)
-		if err == io.EOF {
+		_, copyErr := io.Copy(lconn, stream)
+		if copyErr == io.EOF {
 			// smux Stream.WriteTo may return io.EOF.
-			err = nil
+			copyErr = nil
 		}
-		if err != nil && !errors.Is(err, io.ErrClosedPipe) {
-			log.Printf("stream %08x:%d copy local←stream: %v", t.kcpConn.GetConv(), stream.ID(), err)
+		if copyErr != nil && !errors.Is(copyErr, io.ErrClosedPipe) {
+			log.Printf("stream %08x:%d copy local←stream: %v", t.kcpConn.GetConv(), stream.ID(), copyErr)
+			setErr(copyErr)
 		}
 		lconn.CloseWrite()
 	}()
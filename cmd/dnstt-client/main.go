@@ -5,17 +5,35 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 
 	"github.com/mahsanet/dnstt/client"
 	"github.com/mahsanet/dnstt/noise"
 )
 
+// addrListFlag collects the values of a repeatable -flag into a slice, in
+// the order they were given on the command line.
+type addrListFlag []string
+
+func (f *addrListFlag) String() string { return strings.Join(*f, ",") }
+
+func (f *addrListFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
 func main() {
 	var pubkeyString string
-	var udpAddr string
+	var udpAddrs, dohAddrs, dotAddrs addrListFlag
+	var tlsFingerprint string
+	var loadBalancePolicy string
 
 	flag.StringVar(&pubkeyString, "pubkey", "", fmt.Sprintf("server public key (%d hex digits)", noise.KeyLen*2))
-	flag.StringVar(&udpAddr, "udp", "", "address of UDP DNS resolver")
+	flag.Var(&udpAddrs, "udp", "address of UDP DNS resolver (repeatable)")
+	flag.Var(&dohAddrs, "doh", "URL of DoH resolver, e.g. https://resolver.example/dns-query (repeatable)")
+	flag.Var(&dotAddrs, "dot", "address of DoT resolver, host:port (repeatable)")
+	flag.StringVar(&tlsFingerprint, "tls-fingerprint", client.DefaultTLSFingerprint, "uTLS ClientHello to present for DoH/DoT (chrome, firefox, ios)")
+	flag.StringVar(&loadBalancePolicy, "loadbalance-policy", "round-robin", "policy for dispatching streams across multiple resolvers (round-robin, least-loaded, preference)")
 
 	flag.Parse()
 
@@ -26,15 +44,41 @@ func main() {
 		os.Exit(1)
 	}
 
+	policy, err := client.ParseLoadBalancePolicy(loadBalancePolicy)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid -loadbalance-policy: %v\n", err)
+		os.Exit(1)
+	}
+
 	resolvers := []client.Resolver{}
-	if udpAddr != "" {
-		resolver, err := client.NewResolver(client.ResolverTypeUDP, udpAddr)
+	for _, addr := range udpAddrs {
+		resolver, err := client.NewResolver(client.ResolverTypeUDP, addr, tlsFingerprint)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "invalid -udp address: %v\n", err)
 			os.Exit(1)
 		}
 		resolvers = append(resolvers, resolver)
 	}
+	for _, addr := range dohAddrs {
+		resolver, err := client.NewResolver(client.ResolverTypeDOH, addr, tlsFingerprint)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid -doh address: %v\n", err)
+			os.Exit(1)
+		}
+		resolvers = append(resolvers, resolver)
+	}
+	for _, addr := range dotAddrs {
+		resolver, err := client.NewResolver(client.ResolverTypeDOT, addr, tlsFingerprint)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid -dot address: %v\n", err)
+			os.Exit(1)
+		}
+		resolvers = append(resolvers, resolver)
+	}
+	if len(resolvers) == 0 {
+		fmt.Fprintln(os.Stderr, "at least one of -udp, -doh, -dot is required")
+		os.Exit(1)
+	}
 
 	tServer, err := client.NewTunnelServer(flag.Arg(0), pubkeyString)
 	if err != nil {
@@ -45,6 +89,7 @@ func main() {
 	tunnelServers := []client.TunnelServer{tServer}
 
 	ob := client.NewOutbound(resolvers, tunnelServers)
+	ob.LoadBalancePolicy = policy
 	err = ob.Start(flag.Arg(1))
 	if err != nil {
 		log.Fatal(err)